@@ -0,0 +1,253 @@
+package byzantine
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+	flag "github.com/spf13/pflag"
+	"github.com/spf13/viper"
+
+	"github.com/oasislabs/ekiden/go/common/cbor"
+	"github.com/oasislabs/ekiden/go/common/pubsub"
+	epochtime "github.com/oasislabs/ekiden/go/epochtime/api"
+	epochtime_mockapp "github.com/oasislabs/ekiden/go/tendermint/apps/epochtime_mock"
+	"github.com/oasislabs/ekiden/go/tendermint/service"
+)
+
+const (
+	// cfgEpochInterval configures the height-based fake epochtime mode: the
+	// number of blocks that make up one epoch.
+	cfgEpochInterval = "byzantine.epoch_interval"
+	// cfgEpochSchedule points at a JSON file containing a scripted
+	// height -> epoch schedule, for tests that need to jump epochs
+	// arbitrarily rather than following a fixed interval.
+	cfgEpochSchedule = "byzantine.epoch_schedule"
+	// cfgEpochScheduleControlAddr, if set, makes the byzantine node listen
+	// on this address for runtime schedule pushes from an external harness.
+	cfgEpochScheduleControlAddr = "byzantine.epoch_schedule_control_addr"
+)
+
+// Flags has the configuration flags for the fake epochtime backend.
+var Flags = flag.NewFlagSet("", flag.ContinueOnError)
+
+// scheduleEntry is a single scripted height -> epoch transition.
+type scheduleEntry struct {
+	Height int64               `json:"height"`
+	Epoch  epochtime.EpochTime `json:"epoch"`
+}
+
+var _ epochtime.Backend = (*fakeTimeBackend)(nil)
+
+// fakeTimeBackend is like TendermintBackend (of epochtime), but without
+// any workers. It can run in three modes: mocked (delegating to the
+// epochtime_mock Tendermint application), height-based (epoch =
+// height / epochInterval), or scripted (epoch transitions come from an
+// explicit height -> epoch schedule that a harness can extend at runtime).
+type fakeTimeBackend struct {
+	sync.Mutex
+
+	service service.TendermintService
+
+	useMockEpochTime bool
+	epochInterval    int64
+
+	schedule []scheduleEntry
+	notifier *pubsub.Broker
+}
+
+// newFakeTimeBackend constructs a fakeTimeBackend configured from the
+// byzantine.epoch_interval and byzantine.epoch_schedule flags, and starts
+// its runtime schedule control endpoint if one is configured.
+func newFakeTimeBackend(svc service.TendermintService, useMockEpochTime bool) *fakeTimeBackend {
+	t := &fakeTimeBackend{
+		service:          svc,
+		useMockEpochTime: useMockEpochTime,
+		epochInterval:    viper.GetInt64(cfgEpochInterval),
+		notifier:         pubsub.NewBroker(false),
+	}
+	if t.epochInterval <= 0 {
+		t.epochInterval = 30
+	}
+
+	if schedulePath := viper.GetString(cfgEpochSchedule); schedulePath != "" {
+		schedule, err := loadEpochSchedule(schedulePath)
+		if err != nil {
+			logger.Error("byzantine: failed to load epoch schedule, falling back to height-based mode",
+				"err", err,
+				"path", schedulePath,
+			)
+		} else {
+			t.schedule = schedule
+		}
+	}
+
+	if addr := viper.GetString(cfgEpochScheduleControlAddr); addr != "" {
+		startEpochScheduleController(t, addr)
+	}
+
+	return t
+}
+
+func loadEpochSchedule(path string) ([]scheduleEntry, error) {
+	var schedule []scheduleEntry
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "byzantine: reading epoch schedule")
+	}
+	if err := json.Unmarshal(raw, &schedule); err != nil {
+		return nil, errors.Wrap(err, "byzantine: parsing epoch schedule")
+	}
+	sort.Slice(schedule, func(i, j int) bool { return schedule[i].Height < schedule[j].Height })
+	return schedule, nil
+}
+
+// PushScheduleEntry adds (or replaces) a scripted height -> epoch
+// transition and immediately broadcasts epoch to any WatchEpochs
+// subscribers, whether or not height has been reached yet. It is exposed
+// so an external harness can coordinate epoch
+// transitions with scripted byzantine actions at runtime, either by
+// calling it directly in-process or via the control endpoint below.
+func (t *fakeTimeBackend) PushScheduleEntry(height int64, epoch epochtime.EpochTime) {
+	t.Lock()
+	defer t.Unlock()
+
+	for i, e := range t.schedule {
+		if e.Height == height {
+			t.schedule[i].Epoch = epoch
+			t.notifier.Broadcast(epoch)
+			return
+		}
+	}
+
+	t.schedule = append(t.schedule, scheduleEntry{Height: height, Epoch: epoch})
+	sort.Slice(t.schedule, func(i, j int) bool { return t.schedule[i].Height < t.schedule[j].Height })
+	t.notifier.Broadcast(epoch)
+}
+
+// GetEpoch implements epochtime Backend.
+func (t *fakeTimeBackend) GetEpoch(ctx context.Context, height int64) (epochtime.EpochTime, error) {
+	if height == 0 {
+		panic("0 height not supported")
+	}
+
+	if t.useMockEpochTime {
+		// Query the epochtime_mock Tendermint application.
+		response, err := t.service.Query(epochtime_mockapp.QueryGetEpoch, nil, height)
+		if err != nil {
+			return 0, errors.Wrap(err, "epochtime: get block epoch query failed")
+		}
+
+		var data epochtime_mockapp.QueryGetEpochResponse
+		if err := cbor.Unmarshal(response, &data); err != nil {
+			return 0, errors.Wrap(err, "epochtime: get block epoch malformed response")
+		}
+
+		return data.Epoch, nil
+	}
+
+	t.Lock()
+	defer t.Unlock()
+
+	if len(t.schedule) > 0 {
+		return t.epochAtHeightLocked(height), nil
+	}
+
+	return epochtime.EpochTime(height / t.epochInterval), nil
+}
+
+// epochAtHeightLocked returns the epoch in effect at height according to
+// the scripted schedule. Callers must hold t.Lock.
+func (t *fakeTimeBackend) epochAtHeightLocked(height int64) epochtime.EpochTime {
+	var epoch epochtime.EpochTime
+	for _, e := range t.schedule {
+		if e.Height > height {
+			break
+		}
+		epoch = e.Epoch
+	}
+	return epoch
+}
+
+// GetEpochBlock implements epochtime Backend.
+func (t *fakeTimeBackend) GetEpochBlock(ctx context.Context, epoch epochtime.EpochTime) (int64, error) {
+	t.Lock()
+	defer t.Unlock()
+
+	if len(t.schedule) == 0 {
+		return int64(epoch) * t.epochInterval, nil
+	}
+
+	for _, e := range t.schedule {
+		if e.Epoch == epoch {
+			return e.Height, nil
+		}
+	}
+
+	return 0, errors.Errorf("epochtime: epoch %d not present in schedule", epoch)
+}
+
+// WatchEpochs implements epochtime Backend.
+func (t *fakeTimeBackend) WatchEpochs() (<-chan epochtime.EpochTime, *pubsub.Subscription) {
+	typedCh := make(chan epochtime.EpochTime)
+	sub := t.notifier.Subscribe()
+	sub.Unwrap(typedCh)
+
+	return typedCh, sub
+}
+
+// epochScheduleController exposes PushScheduleEntry over a tiny local HTTP
+// endpoint so that an external test harness can coordinate epoch
+// transitions with adversarial scenario actions without sharing a process.
+type epochScheduleController struct {
+	backend *fakeTimeBackend
+	server  *http.Server
+}
+
+func startEpochScheduleController(backend *fakeTimeBackend, addr string) *epochScheduleController {
+	c := &epochScheduleController{backend: backend}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/schedule", c.handlePush)
+	c.server = &http.Server{Addr: addr, Handler: mux}
+
+	go func() {
+		if err := c.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.Error("byzantine: epoch schedule controller stopped", "err", err)
+		}
+	}()
+
+	logger.Info("byzantine: epoch schedule control endpoint listening", "addr", addr)
+
+	return c
+}
+
+func (c *epochScheduleController) handlePush(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var entry scheduleEntry
+	if err := json.NewDecoder(r.Body).Decode(&entry); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	c.backend.PushScheduleEntry(entry.Height, entry.Epoch)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func init() {
+	Flags.Int64(cfgEpochInterval, 30, "fake epochtime: blocks per epoch in height-based mode")
+	Flags.String(cfgEpochSchedule, "", "fake epochtime: path to a JSON height->epoch schedule for scripted mode")
+	Flags.String(cfgEpochScheduleControlAddr, "", "fake epochtime: address to serve a runtime schedule-push endpoint on")
+
+	for _, v := range []string{cfgEpochInterval, cfgEpochSchedule, cfgEpochScheduleControlAddr} {
+		_ = viper.BindPFlag(v, Flags.Lookup(v))
+	}
+}
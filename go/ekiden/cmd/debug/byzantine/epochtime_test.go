@@ -0,0 +1,108 @@
+package byzantine
+
+import (
+	"context"
+	"testing"
+
+	"github.com/oasislabs/ekiden/go/common/pubsub"
+	epochtime "github.com/oasislabs/ekiden/go/epochtime/api"
+)
+
+func TestFakeTimeBackendGetEpochHeightBased(t *testing.T) {
+	fb := &fakeTimeBackend{epochInterval: 30}
+
+	epoch, err := fb.GetEpoch(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("GetEpoch: %v", err)
+	}
+	if epoch != 0 {
+		t.Fatalf("GetEpoch(1) = %d, want 0", epoch)
+	}
+
+	epoch, err = fb.GetEpoch(context.Background(), 61)
+	if err != nil {
+		t.Fatalf("GetEpoch: %v", err)
+	}
+	if epoch != 2 {
+		t.Fatalf("GetEpoch(61) = %d, want 2", epoch)
+	}
+}
+
+func TestFakeTimeBackendGetEpochScripted(t *testing.T) {
+	fb := &fakeTimeBackend{
+		epochInterval: 30,
+		schedule: []scheduleEntry{
+			{Height: 1, Epoch: 0},
+			{Height: 10, Epoch: 1},
+			{Height: 25, Epoch: 5},
+		},
+	}
+
+	cases := []struct {
+		height int64
+		want   epochtime.EpochTime
+	}{
+		{1, 0},
+		{5, 0},
+		{10, 1},
+		{24, 1},
+		{25, 5},
+		{1000, 5},
+	}
+	for _, c := range cases {
+		got, err := fb.GetEpoch(context.Background(), c.height)
+		if err != nil {
+			t.Fatalf("GetEpoch(%d): %v", c.height, err)
+		}
+		if got != c.want {
+			t.Errorf("GetEpoch(%d) = %d, want %d", c.height, got, c.want)
+		}
+	}
+}
+
+func TestFakeTimeBackendGetEpochBlock(t *testing.T) {
+	fb := &fakeTimeBackend{epochInterval: 30}
+
+	height, err := fb.GetEpochBlock(context.Background(), 3)
+	if err != nil {
+		t.Fatalf("GetEpochBlock: %v", err)
+	}
+	if height != 90 {
+		t.Fatalf("GetEpochBlock(3) = %d, want 90", height)
+	}
+
+	fb.schedule = []scheduleEntry{
+		{Height: 1, Epoch: 0},
+		{Height: 10, Epoch: 1},
+	}
+
+	height, err = fb.GetEpochBlock(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("GetEpochBlock: %v", err)
+	}
+	if height != 10 {
+		t.Fatalf("GetEpochBlock(1) = %d, want 10", height)
+	}
+
+	if _, err := fb.GetEpochBlock(context.Background(), 99); err == nil {
+		t.Fatal("GetEpochBlock(99) should fail for an epoch not in the schedule")
+	}
+}
+
+func TestFakeTimeBackendPushScheduleEntry(t *testing.T) {
+	fb := &fakeTimeBackend{epochInterval: 30, notifier: pubsub.NewBroker(false)}
+
+	fb.PushScheduleEntry(10, 1)
+	fb.PushScheduleEntry(1, 0)
+	fb.PushScheduleEntry(10, 2) // replaces the height-10 entry rather than duplicating it.
+
+	if len(fb.schedule) != 2 {
+		t.Fatalf("len(schedule) = %d, want 2", len(fb.schedule))
+	}
+	if fb.schedule[0].Height != 1 || fb.schedule[1].Height != 10 {
+		t.Fatalf("schedule not sorted by height: %+v", fb.schedule)
+	}
+	if fb.schedule[1].Epoch != 2 {
+		t.Fatalf("schedule[1].Epoch = %d, want 2", fb.schedule[1].Epoch)
+	}
+}
@@ -0,0 +1,296 @@
+package byzantine
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	tmtypes "github.com/tendermint/tendermint/types"
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/oasislabs/ekiden/go/common/identity"
+	"github.com/oasislabs/ekiden/go/tendermint/service"
+)
+
+// ActionKind identifies a single scripted adversarial behaviour that a
+// ScenarioRunner can execute while the byzantine node is live.
+type ActionKind string
+
+const (
+	// ActionEquivocate casts two conflicting votes for the same (height, round, step).
+	ActionEquivocate ActionKind = "equivocate"
+	// ActionCensorVotes withholds pre-votes for a given validator for N blocks.
+	ActionCensorVotes ActionKind = "censor_votes"
+	// ActionDelayCommit sleeps the runner for DelayMs before it goes on to the
+	// next due action; Tendermint's own commit/precommit gossip is not held back.
+	ActionDelayCommit ActionKind = "delay_commit"
+	// ActionProposeInvalid proposes a block that fails application-level validation.
+	ActionProposeInvalid ActionKind = "propose_invalid"
+	// ActionDoubleSign signs and broadcasts two distinct blocks at the same height.
+	ActionDoubleSign ActionKind = "double_sign"
+	// ActionRefuseBroadcast silently drops our own proposals instead of gossiping them.
+	ActionRefuseBroadcast ActionKind = "refuse_broadcast"
+	// ActionConflictingGossip gossips mutually exclusive block parts to disjoint peer subsets.
+	ActionConflictingGossip ActionKind = "conflicting_gossip"
+)
+
+// Action describes a single timed adversarial action.
+type Action struct {
+	// Height is the consensus height at which this action should fire.
+	Height int64 `json:"height" yaml:"height"`
+	// Round is the consensus round at which this action should fire.
+	Round int `json:"round" yaml:"round"`
+	// Kind identifies which adversarial behaviour to run.
+	Kind ActionKind `json:"kind" yaml:"kind"`
+
+	// Validator is the target validator address, used by ActionCensorVotes.
+	Validator string `json:"validator,omitempty" yaml:"validator,omitempty"`
+	// Blocks is the number of blocks an action should stay in effect for.
+	Blocks int64 `json:"blocks,omitempty" yaml:"blocks,omitempty"`
+	// DelayMs is the delay in milliseconds, used by ActionDelayCommit.
+	DelayMs int64 `json:"delay_ms,omitempty" yaml:"delay_ms,omitempty"`
+	// Epoch is the epoch to claim in an invalid proposal, used by ActionProposeInvalid.
+	Epoch uint64 `json:"epoch,omitempty" yaml:"epoch,omitempty"`
+	// Peers restricts ActionConflictingGossip to the named peer subset.
+	Peers []string `json:"peers,omitempty" yaml:"peers,omitempty"`
+}
+
+// Script is an ordered set of scripted actions, as loaded from a YAML or JSON file.
+type Script struct {
+	Actions []Action `json:"actions" yaml:"actions"`
+}
+
+// LoadScript reads a Script from path. JSON and YAML are both accepted; the
+// format is picked by looking at the file's contents rather than its
+// extension so that either can be used interchangeably.
+func LoadScript(path string) (*Script, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "byzantine: reading scenario script")
+	}
+
+	var script Script
+	trimmed := strings.TrimSpace(string(raw))
+	if strings.HasPrefix(trimmed, "{") {
+		if err := json.Unmarshal(raw, &script); err != nil {
+			return nil, errors.Wrap(err, "byzantine: parsing scenario script as JSON")
+		}
+		return &script, nil
+	}
+
+	if err := yaml.Unmarshal(raw, &script); err != nil {
+		return nil, errors.Wrap(err, "byzantine: parsing scenario script as YAML")
+	}
+	return &script, nil
+}
+
+// ActionResult records whether a scripted action actually fired, and when.
+type ActionResult struct {
+	Action  Action    `json:"action"`
+	Fired   bool      `json:"fired"`
+	At      time.Time `json:"at,omitempty"`
+	Height  int64     `json:"height,omitempty"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// Summary is the JSON-serializable report of what a ScenarioRunner actually did.
+type Summary struct {
+	Results []ActionResult `json:"results"`
+}
+
+// ScenarioRunner drives a scripted sequence of adversarial actions against a
+// live service.TendermintService, hooking into the same primitives that the
+// honestTendermint wiring uses (tendermintBroadcastTxCommit and friends).
+//
+// It is intentionally decoupled from any one attack: each Action is executed
+// by a small dedicated hook, so new adversarial behaviours can be added
+// without touching the scheduling loop below.
+//
+// Scope: only the actions that are reachable through
+// service.TendermintService's BroadcastTx/Query/Subscribe surface
+// (equivocate, double_sign, propose_invalid) actually run, plus
+// delay_commit, which only sleeps the runner itself.
+// censor_votes, refuse_broadcast and conflicting_gossip need a hook into
+// Tendermint's own vote-relay or p2p gossip internals, which this debug
+// tool has no access to; see errUnimplementedAction below. They stay in the
+// schema so existing scripts parse and the gap shows up in the summary
+// instead of being silently dropped, but wiring them up requires a change
+// to vendored Tendermint itself, not this package.
+type ScenarioRunner struct {
+	sync.Mutex
+
+	svc     service.TendermintService
+	id      *identity.Identity
+	chainID string
+
+	pending []Action
+	results []ActionResult
+}
+
+// NewScenarioRunner constructs a ScenarioRunner that will execute script
+// against svc, signing adversarial messages with id for the given chainID.
+func NewScenarioRunner(svc service.TendermintService, id *identity.Identity, chainID string, script *Script) *ScenarioRunner {
+	return &ScenarioRunner{
+		svc:     svc,
+		id:      id,
+		chainID: chainID,
+		pending: append([]Action{}, script.Actions...),
+	}
+}
+
+// OnBlock should be invoked by the caller for every new block height/round
+// observed on svc. It fires (in order) every pending action whose Height and
+// Round have been reached.
+func (r *ScenarioRunner) OnBlock(height int64, round int) {
+	r.Lock()
+	var due []Action
+	var rest []Action
+	for _, a := range r.pending {
+		if a.Height <= height && a.Round <= round {
+			due = append(due, a)
+			continue
+		}
+		rest = append(rest, a)
+	}
+	r.pending = rest
+	r.Unlock()
+
+	for _, a := range due {
+		res := ActionResult{Action: a, At: time.Now(), Height: height}
+		if err := r.fire(a, height, round); err != nil {
+			res.Error = err.Error()
+			logger.Error("byzantine: scenario action failed",
+				"kind", a.Kind,
+				"height", height,
+				"round", round,
+				"err", err,
+			)
+		} else {
+			res.Fired = true
+			logger.Info("byzantine: scenario action fired",
+				"kind", a.Kind,
+				"height", height,
+				"round", round,
+			)
+		}
+
+		r.Lock()
+		r.results = append(r.results, res)
+		r.Unlock()
+	}
+}
+
+// Summary returns a JSON-serializable report of every action that has fired
+// (or failed to) so far.
+func (r *ScenarioRunner) Summary() Summary {
+	r.Lock()
+	defer r.Unlock()
+
+	return Summary{Results: append([]ActionResult{}, r.results...)}
+}
+
+// errUnimplementedAction is returned by fire for action kinds that this
+// checkout cannot actually carry out: honestTendermint only exposes
+// BroadcastTx/Query/Subscribe on service.TendermintService, with no hook
+// into Tendermint's own vote-relay or p2p gossip paths, so these kinds
+// cannot honestly report Fired: true. They are kept in the Script schema
+// so existing scripts parse and the gap is visible in the summary, rather
+// than silently dropped.
+func errUnimplementedAction(kind ActionKind) error {
+	return errors.Errorf("byzantine: action kind %q is not wired into any gossip/broadcast path in this build; recorded but not executed", kind)
+}
+
+func (r *ScenarioRunner) fire(a Action, height int64, round int) error {
+	switch a.Kind {
+	case ActionEquivocate:
+		return r.fireEquivocate(a, height, round, tmtypes.PrevoteType)
+	case ActionCensorVotes:
+		return errUnimplementedAction(a.Kind)
+	case ActionDelayCommit:
+		time.Sleep(time.Duration(a.DelayMs) * time.Millisecond)
+		return nil
+	case ActionProposeInvalid:
+		return r.fireProposeInvalid(a, height, round)
+	case ActionDoubleSign:
+		return r.fireEquivocate(a, height, round, tmtypes.PrecommitType)
+	case ActionRefuseBroadcast:
+		return errUnimplementedAction(a.Kind)
+	case ActionConflictingGossip:
+		return errUnimplementedAction(a.Kind)
+	default:
+		return errors.Errorf("byzantine: unknown scenario action %q", a.Kind)
+	}
+}
+
+// fireEquivocate casts two conflicting votes for the same (height, round,
+// step) by signing a second vote for a different BlockID and broadcasting
+// both. It is shared by ActionEquivocate (step PrevoteType) and
+// ActionDoubleSign (step PrecommitType), which differ only in which
+// consensus step they target.
+func (r *ScenarioRunner) fireEquivocate(a Action, height int64, round int, step tmtypes.SignedMsgType) error {
+	blockA := tmtypes.BlockID{Hash: tmtypes.Tx(r.svc.MarshalTx(0, a)).Hash()}
+	blockB := tmtypes.BlockID{Hash: tmtypes.Tx(append(tmtypes.Tx(r.svc.MarshalTx(0, a)), 0xff)).Hash()}
+
+	voteA, voteB, err := signEquivocatingVotes(r.id, r.chainID, height, round, step, blockA, blockB)
+	if err != nil {
+		return errors.Wrap(err, "byzantine: signing equivocating votes")
+	}
+
+	if err := r.svc.BroadcastTx(0, voteA); err != nil {
+		return errors.Wrap(err, "byzantine: broadcasting first equivocating vote")
+	}
+	if err := r.svc.BroadcastTx(0, voteB); err != nil {
+		return errors.Wrap(err, "byzantine: broadcasting second equivocating vote")
+	}
+
+	return nil
+}
+
+func (r *ScenarioRunner) fireProposeInvalid(a Action, height int64, round int) error {
+	// A deliberately malformed proposal: claim an epoch that the application
+	// layer cannot have seen yet, so any honest validator rejects it.
+	return errors.Wrap(
+		tendermintBroadcastTxCommit(r.svc, 0, struct {
+			Height int64
+			Round  int
+			Epoch  uint64
+		}{height, round, a.Epoch}),
+		"byzantine: broadcasting invalid proposal",
+	)
+}
+
+// signEquivocatingVotes produces two validly signed votes for the same
+// (height, round, step) differing only in BlockID, using id's consensus key.
+func signEquivocatingVotes(id *identity.Identity, chainID string, height int64, round int, step tmtypes.SignedMsgType, blockA, blockB tmtypes.BlockID) (*tmtypes.Vote, *tmtypes.Vote, error) {
+	mkVote := func(blockID tmtypes.BlockID) (*tmtypes.Vote, error) {
+		vote := &tmtypes.Vote{
+			Type:      step,
+			Height:    height,
+			Round:     round,
+			BlockID:   blockID,
+			Timestamp: time.Now().UTC(),
+		}
+
+		sig, err := id.ConsensusSigner.Sign(vote.SignBytes(chainID))
+		if err != nil {
+			return nil, err
+		}
+		vote.Signature = sig
+
+		return vote, nil
+	}
+
+	voteA, err := mkVote(blockA)
+	if err != nil {
+		return nil, nil, err
+	}
+	voteB, err := mkVote(blockB)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return voteA, voteB, nil
+}
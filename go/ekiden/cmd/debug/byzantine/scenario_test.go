@@ -0,0 +1,110 @@
+package byzantine
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadScriptJSON(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scenario-json")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "script.json")
+	const contents = `{"actions": [{"height": 10, "round": 1, "kind": "delay_commit", "delay_ms": 5}]}`
+	if err := ioutil.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	script, err := LoadScript(path)
+	if err != nil {
+		t.Fatalf("LoadScript: %v", err)
+	}
+	if len(script.Actions) != 1 {
+		t.Fatalf("len(Actions) = %d, want 1", len(script.Actions))
+	}
+	a := script.Actions[0]
+	if a.Height != 10 || a.Round != 1 || a.Kind != ActionDelayCommit || a.DelayMs != 5 {
+		t.Errorf("unexpected action: %+v", a)
+	}
+}
+
+func TestLoadScriptYAML(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scenario-yaml")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "script.yaml")
+	const contents = "actions:\n  - height: 3\n    round: 0\n    kind: censor_votes\n    validator: deadbeef\n    blocks: 2\n"
+	if err := ioutil.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	script, err := LoadScript(path)
+	if err != nil {
+		t.Fatalf("LoadScript: %v", err)
+	}
+	if len(script.Actions) != 1 {
+		t.Fatalf("len(Actions) = %d, want 1", len(script.Actions))
+	}
+	a := script.Actions[0]
+	if a.Height != 3 || a.Kind != ActionCensorVotes || a.Validator != "deadbeef" || a.Blocks != 2 {
+		t.Errorf("unexpected action: %+v", a)
+	}
+}
+
+// TestScenarioRunnerOnBlock exercises the scheduling/reporting loop using
+// only action kinds that don't need a live service.TendermintService or
+// identity.Identity (neither of which this checkout can construct outside
+// of a running node): delay_commit actually runs, while two of the
+// gossip/broadcast-dependent kinds (censor_votes and refuse_broadcast) are
+// expected to report as unimplemented rather than falsely fired.
+func TestScenarioRunnerOnBlock(t *testing.T) {
+	script := &Script{
+		Actions: []Action{
+			{Height: 1, Round: 0, Kind: ActionDelayCommit, DelayMs: 0},
+			{Height: 2, Round: 0, Kind: ActionCensorVotes},
+			{Height: 5, Round: 0, Kind: ActionRefuseBroadcast},
+		},
+	}
+	r := NewScenarioRunner(nil, nil, "test-chain", script)
+
+	r.OnBlock(1, 0)
+	summary := r.Summary()
+	if len(summary.Results) != 1 {
+		t.Fatalf("after height 1: len(Results) = %d, want 1", len(summary.Results))
+	}
+	if !summary.Results[0].Fired || summary.Results[0].Error != "" {
+		t.Errorf("delay_commit should fire cleanly, got %+v", summary.Results[0])
+	}
+
+	r.OnBlock(2, 0)
+	summary = r.Summary()
+	if len(summary.Results) != 2 {
+		t.Fatalf("after height 2: len(Results) = %d, want 2", len(summary.Results))
+	}
+	if summary.Results[1].Fired || summary.Results[1].Error == "" {
+		t.Errorf("censor_votes is unimplemented and must not report Fired: true, got %+v", summary.Results[1])
+	}
+
+	// The height-5 action must not have fired yet.
+	r.OnBlock(3, 0)
+	if len(r.Summary().Results) != 2 {
+		t.Fatalf("height-5 action fired early: %+v", r.Summary().Results)
+	}
+
+	r.OnBlock(5, 0)
+	summary = r.Summary()
+	if len(summary.Results) != 3 {
+		t.Fatalf("after height 5: len(Results) = %d, want 3", len(summary.Results))
+	}
+	if summary.Results[2].Fired {
+		t.Errorf("refuse_broadcast is unimplemented and must not report Fired: true, got %+v", summary.Results[2])
+	}
+}
@@ -10,10 +10,7 @@ import (
 	tmtypes "github.com/tendermint/tendermint/types"
 
 	beacon "github.com/oasislabs/ekiden/go/beacon/api"
-	"github.com/oasislabs/ekiden/go/common/cbor"
 	"github.com/oasislabs/ekiden/go/common/identity"
-	"github.com/oasislabs/ekiden/go/common/pubsub"
-	epochtime "github.com/oasislabs/ekiden/go/epochtime/api"
 	"github.com/oasislabs/ekiden/go/genesis"
 	registry "github.com/oasislabs/ekiden/go/registry/api"
 	scheduler "github.com/oasislabs/ekiden/go/scheduler/api"
@@ -28,54 +25,14 @@ import (
 	"github.com/oasislabs/ekiden/go/tendermint/service"
 )
 
-var _ epochtime.Backend = (*fakeTimeBackend)(nil)
-
-// fakeTimeBackend is like TendermintBackend (of epochtime), but without
-// any workers.
-type fakeTimeBackend struct {
-	service service.TendermintService
-
-	useMockEpochTime bool
-}
-
-// GetEpoch implements epochtime Backend.
-func (t *fakeTimeBackend) GetEpoch(ctx context.Context, height int64) (epochtime.EpochTime, error) {
-	if height == 0 {
-		panic("0 height not supported")
-	}
-
-	if t.useMockEpochTime {
-		// Query the epochtime_mock Tendermint application.
-		response, err := t.service.Query(epochtime_mockapp.QueryGetEpoch, nil, height)
-		if err != nil {
-			return 0, errors.Wrap(err, "epochtime: get block epoch query failed")
-		}
-
-		var data epochtime_mockapp.QueryGetEpochResponse
-		if err := cbor.Unmarshal(response, &data); err != nil {
-			return 0, errors.Wrap(err, "epochtime: get block epoch malformed response")
-		}
-
-		return data.Epoch, nil
-	}
-
-	// Use the the epoch interval that we have in E2E tests.
-	// We could make this more flexible with command line flags in future work.
-	return epochtime.EpochTime(height / 30), nil
-}
-
-// GetEpochBlock implements epochtime Backend.
-func (*fakeTimeBackend) GetEpochBlock(ctx context.Context, epoch epochtime.EpochTime) (int64, error) {
-	panic("GetEpochBlock not supported")
-}
-
-// WatchEpochs implements epochtime Backend.
-func (*fakeTimeBackend) WatchEpochs() (<-chan epochtime.EpochTime, *pubsub.Subscription) {
-	panic("WatchEpochs not supported")
-}
+// fakeTimeBackend is defined in epochtime.go: it is like TendermintBackend
+// (of epochtime), but without any workers, and configurable enough to drive
+// epoch transitions from an external test harness.
 
 type honestTendermint struct {
 	service service.TendermintService
+
+	scenario *ScenarioRunner
 }
 
 func newHonestTendermint() *honestTendermint {
@@ -101,10 +58,7 @@ func (ht *honestTendermint) start(id *identity.Identity, dataDir string, useMock
 	// This isn't very flexible. It's configured to match what we use in end-to-end tests.
 	// And we do that mostly by hardcoding options. We could make this more flexible with command
 	// line flags in future work.
-	timeSource := &fakeTimeBackend{
-		service:          ht.service,
-		useMockEpochTime: useMockEpochTime,
-	}
+	timeSource := newFakeTimeBackend(ht.service, useMockEpochTime)
 	if useMockEpochTime {
 		if err := ht.service.RegisterApplication(epochtime_mockapp.New()); err != nil {
 			return errors.Wrap(err, "honest Tendermint service RegisterApplication epochtime_mock")
@@ -149,6 +103,46 @@ func (ht *honestTendermint) start(id *identity.Identity, dataDir string, useMock
 	return nil
 }
 
+// runScenario loads scriptPath and starts a ScenarioRunner that fires its
+// actions as new blocks arrive on ht.service, so that adversarial behaviour
+// stays reproducible across runs instead of living in one-off binaries.
+func (ht *honestTendermint) runScenario(id *identity.Identity, chainID string, scriptPath string) error {
+	if ht.service == nil {
+		return errors.New("honest Tendermint service not started")
+	}
+
+	script, err := LoadScript(scriptPath)
+	if err != nil {
+		return errors.Wrap(err, "runScenario LoadScript")
+	}
+	ht.scenario = NewScenarioRunner(ht.service, id, chainID, script)
+
+	sub, err := ht.service.Subscribe("script", tmtypes.EventQueryNewBlock)
+	if err != nil {
+		return errors.Wrap(err, "runScenario Subscribe")
+	}
+
+	go func() {
+		for {
+			select {
+			case ev, ok := <-sub.Out():
+				if !ok {
+					return
+				}
+				data, ok := ev.Data().(tmtypes.EventDataNewBlock)
+				if !ok {
+					continue
+				}
+				ht.scenario.OnBlock(data.Block.Height, data.Block.LastCommit.Round())
+			case <-sub.Cancelled():
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
 func (ht honestTendermint) stop() error {
 	if ht.service == nil {
 		return errors.New("honest Tendermint service not started")
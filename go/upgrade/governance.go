@@ -0,0 +1,267 @@
+package upgrade
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	flag "github.com/spf13/pflag"
+	"github.com/spf13/viper"
+
+	beacon "github.com/oasisprotocol/oasis-core/go/beacon/api"
+	"github.com/oasisprotocol/oasis-core/go/common/logging"
+	"github.com/oasisprotocol/oasis-core/go/common/persistent"
+	"github.com/oasisprotocol/oasis-core/go/common/pubsub"
+	"github.com/oasisprotocol/oasis-core/go/upgrade/api"
+)
+
+const (
+	// cfgAutoApproveFromGovernance opts a node into automatically submitting
+	// upgrade descriptors approved on-chain via the governance app, instead
+	// of requiring every operator to run SubmitDescriptor by hand.
+	cfgAutoApproveFromGovernance = "upgrade.auto_approve_from_governance"
+	// cfgMinNotice is the minimum number of epochs that must remain between
+	// the current epoch and a descriptor's upgrade epoch for it to be
+	// accepted, giving operators time to stage the new binary.
+	cfgMinNotice = "upgrade.min_notice"
+	// cfgQuorumNumerator and cfgQuorumDenominator define the fraction of
+	// total stake that must vote in favor of a proposed upgrade for it to be
+	// considered governance-approved, as quorumNumerator/quorumDenominator.
+	cfgQuorumNumerator   = "upgrade.governance_quorum_numerator"
+	cfgQuorumDenominator = "upgrade.governance_quorum_denominator"
+)
+
+// Flags has the configuration flags for governance-driven upgrades.
+var Flags = flag.NewFlagSet("", flag.ContinueOnError)
+
+func tallyStoreKey(name string) []byte {
+	return []byte(fmt.Sprintf("governance-tally-%s", name))
+}
+
+// quorumReached reports whether approvingStake out of totalStake clears the
+// numerator/denominator quorum fraction. A zero denominator is treated as 1,
+// a zero numerator reaches quorum for any approving stake (zero included)
+// as long as totalStake is non-zero, and a zero totalStake never reaches
+// quorum, since there is nothing to weigh votes against.
+func quorumReached(approvingStake, totalStake, numerator, denominator uint64) bool {
+	if totalStake == 0 {
+		return false
+	}
+	if denominator == 0 {
+		denominator = 1
+	}
+	return approvingStake*denominator >= totalStake*numerator
+}
+
+// voteAllowed reports whether currentEpoch falls within [startEpoch,
+// endEpoch], the window during which votes on a proposal are accepted. A
+// zero endEpoch means "no end set", i.e. the window never closes.
+func voteAllowed(currentEpoch, startEpoch, endEpoch beacon.EpochTime) bool {
+	if currentEpoch < startEpoch {
+		return false
+	}
+	if endEpoch != 0 && currentEpoch > endEpoch {
+		return false
+	}
+	return true
+}
+
+// governanceTally is the persisted, stake-weighted vote tally for a single
+// proposed upgrade.
+type governanceTally struct {
+	Descriptor *api.Descriptor
+	// VotingStartEpoch and VotingEndEpoch bound the window during which
+	// votes on this proposal are accepted, as recorded from the first vote
+	// submitted for it. A zero VotingEndEpoch means the window never closes.
+	VotingStartEpoch beacon.EpochTime
+	VotingEndEpoch   beacon.EpochTime
+	// Votes maps each voter's signer identity (as a string, e.g. its
+	// public key) to the stake it cast in favor of the upgrade.
+	Votes map[string]uint64
+	// Approved records whether this tally already crossed quorum and
+	// emitted its UpgradeApproved event, so that a restart or state-sync
+	// catch-up never re-emits the same approval.
+	Approved bool
+}
+
+// GovernanceEventSource is watched for UpgradeApproved events emitted once a
+// proposed upgrade's on-chain vote tally reaches quorum. *GovernanceTally is
+// the only implementation in this package; it is kept as an interface so
+// upgradeManager does not need to depend on how votes arrive on-chain.
+//
+// NOTE: this package is a local vote-tally library, not a network-wide
+// governance mechanism. Reaching quorum requires every validator's
+// GovernanceTally to observe the same votes in the same order, which in
+// turn requires a tendermint application that accepts signed
+// ProposeUpgrade/Vote transactions through consensus and calls SubmitVote
+// identically on every node — that application doesn't exist anywhere in
+// this tree (go/tendermint/apps isn't part of this checkout at all, so
+// there's no existing app package to extend). Until it's built and wired
+// up to call SubmitVote, two nodes running this code have no shared
+// transaction source and will not agree on whether quorum was reached;
+// treat GovernanceTally as plumbing a future app will drive, not as a
+// working "governance-driven upgrades" feature.
+type GovernanceEventSource interface {
+	WatchApprovedUpgrades() (<-chan *api.Descriptor, *pubsub.Subscription)
+}
+
+// GovernanceTally tracks stake-weighted votes for proposed upgrades and
+// emits UpgradeApproved once a proposal's approving stake crosses the
+// configured quorum. Tallies are persisted via CBOR so that a restarted or
+// state-synced node recovers in-progress votes instead of re-counting from
+// zero, and so an already-approved proposal is never re-emitted. See the
+// GovernanceEventSource doc comment for what's still missing before this
+// is a real network-wide governance mechanism rather than a per-node
+// counter.
+type GovernanceTally struct {
+	sync.Mutex
+
+	store      *persistent.ServiceStore
+	totalStake func() uint64
+	notifier   *pubsub.Broker
+	logger     *logging.Logger
+}
+
+// NewGovernanceTally constructs a GovernanceTally that persists its state to
+// store and consults totalStake for the network's current total stake each
+// time a vote is tallied.
+func NewGovernanceTally(store *persistent.ServiceStore, totalStake func() uint64) *GovernanceTally {
+	return &GovernanceTally{
+		store:      store,
+		totalStake: totalStake,
+		notifier:   pubsub.NewBroker(false),
+		logger:     logging.GetLogger(api.ModuleName + "/governance"),
+	}
+}
+
+// SubmitVote records voter's stake-weighted vote for the upgrade described
+// by descriptor, bounded by the [votingStartEpoch, votingEndEpoch] window
+// (recorded from the first vote seen for this descriptor; a zero
+// votingEndEpoch never closes the window). It is meant to be called by a
+// governance tendermint application once it has verified voter's signature
+// over the vote and looked up its current stake and the proposal's voting
+// window from on-chain state; once the approving stake crosses quorum,
+// descriptor is broadcast exactly once on WatchApprovedUpgrades.
+func (g *GovernanceTally) SubmitVote(descriptor *api.Descriptor, voter string, stake uint64, currentEpoch, votingStartEpoch, votingEndEpoch beacon.EpochTime) error {
+	g.Lock()
+	defer g.Unlock()
+
+	key := tallyStoreKey(descriptor.Name)
+
+	var tally governanceTally
+	switch err := g.store.GetCBOR(key, &tally); err {
+	case nil:
+	case persistent.ErrNotFound:
+		tally = governanceTally{
+			Descriptor:       descriptor,
+			VotingStartEpoch: votingStartEpoch,
+			VotingEndEpoch:   votingEndEpoch,
+			Votes:            make(map[string]uint64),
+		}
+	default:
+		return fmt.Errorf("can't load existing vote tally: %w", err)
+	}
+
+	if tally.Approved {
+		// Already reached quorum; further votes don't change the outcome.
+		return nil
+	}
+
+	if !voteAllowed(currentEpoch, tally.VotingStartEpoch, tally.VotingEndEpoch) {
+		return fmt.Errorf("upgrade: vote for %q at epoch %d falls outside its voting window [%d, %d]",
+			descriptor.Name, currentEpoch, tally.VotingStartEpoch, tally.VotingEndEpoch)
+	}
+
+	if tally.Votes == nil {
+		tally.Votes = make(map[string]uint64)
+	}
+	tally.Votes[voter] = stake
+
+	var approving uint64
+	for _, s := range tally.Votes {
+		approving += s
+	}
+
+	if quorumReached(approving, g.totalStake(), viper.GetUint64(cfgQuorumNumerator), viper.GetUint64(cfgQuorumDenominator)) {
+		tally.Approved = true
+		g.logger.Info("upgrade proposal reached governance quorum",
+			"name", descriptor.Name,
+			"approving_stake", approving,
+		)
+	}
+
+	if err := g.store.PutCBOR(key, &tally); err != nil {
+		return fmt.Errorf("can't persist vote tally: %w", err)
+	}
+
+	if tally.Approved {
+		g.notifier.Broadcast(descriptor)
+	}
+
+	return nil
+}
+
+// WatchApprovedUpgrades implements GovernanceEventSource.
+func (g *GovernanceTally) WatchApprovedUpgrades() (<-chan *api.Descriptor, *pubsub.Subscription) {
+	typedCh := make(chan *api.Descriptor)
+	sub := g.notifier.Subscribe()
+	sub.Unwrap(typedCh)
+	return typedCh, sub
+}
+
+// WatchApprovedUpgrades subscribes to source and, if
+// upgrade.auto_approve_from_governance is set, locally submits every
+// UpgradeApproved descriptor it observes so that no manual per-node action
+// is required to follow a network-wide upgrade vote. It returns
+// immediately; the watch loop runs until ctx is cancelled.
+func (u *upgradeManager) WatchApprovedUpgrades(ctx context.Context, source GovernanceEventSource) {
+	if !viper.GetBool(cfgAutoApproveFromGovernance) {
+		u.logger.Debug("governance-driven upgrades disabled, not watching for approvals")
+		return
+	}
+
+	ch, sub := source.WatchApprovedUpgrades()
+	go func() {
+		defer sub.Close()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case descriptor, ok := <-ch:
+				if !ok {
+					return
+				}
+
+				u.logger.Info("received governance-approved upgrade, submitting locally",
+					"name", descriptor.Name,
+					"epoch", descriptor.Epoch,
+				)
+
+				if err := u.SubmitDescriptor(ctx, descriptor); err != nil {
+					u.logger.Error("failed to auto-submit governance-approved upgrade",
+						"name", descriptor.Name,
+						"err", err,
+					)
+				}
+			}
+		}
+	}()
+}
+
+// minNoticeEpochs returns the configured minimum notice period, in epochs,
+// that a submitted descriptor's upgrade epoch must clear.
+func minNoticeEpochs() beacon.EpochTime {
+	return beacon.EpochTime(viper.GetUint64(cfgMinNotice))
+}
+
+func init() {
+	Flags.Bool(cfgAutoApproveFromGovernance, false, "automatically submit upgrade descriptors approved by on-chain governance vote")
+	Flags.Uint64(cfgMinNotice, 0, "minimum number of epochs required between the current epoch and a submitted descriptor's upgrade epoch")
+	Flags.Uint64(cfgQuorumNumerator, 2, "numerator of the stake fraction required for a governance upgrade vote to reach quorum")
+	Flags.Uint64(cfgQuorumDenominator, 3, "denominator of the stake fraction required for a governance upgrade vote to reach quorum")
+
+	for _, v := range []string{cfgAutoApproveFromGovernance, cfgMinNotice, cfgQuorumNumerator, cfgQuorumDenominator} {
+		_ = viper.BindPFlag(v, Flags.Lookup(v))
+	}
+}
@@ -0,0 +1,52 @@
+package upgrade
+
+import (
+	"testing"
+
+	beacon "github.com/oasisprotocol/oasis-core/go/beacon/api"
+)
+
+func TestQuorumReached(t *testing.T) {
+	for _, tc := range []struct {
+		name                       string
+		approvingStake, totalStake uint64
+		numerator, denominator     uint64
+		want                       bool
+	}{
+		{"below threshold", 1, 3, 2, 3, false},
+		{"at threshold", 2, 3, 2, 3, true},
+		{"above threshold", 3, 3, 2, 3, true},
+		{"zero total stake never reaches quorum", 0, 0, 2, 3, false},
+		{"zero denominator treated as 1", 2, 2, 1, 0, true},
+		{"zero numerator with any approving stake", 1, 100, 0, 1, true},
+		{"zero numerator and zero approving stake", 0, 100, 0, 1, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := quorumReached(tc.approvingStake, tc.totalStake, tc.numerator, tc.denominator); got != tc.want {
+				t.Errorf("quorumReached(%d, %d, %d, %d) = %v, want %v",
+					tc.approvingStake, tc.totalStake, tc.numerator, tc.denominator, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestVoteAllowed(t *testing.T) {
+	for _, tc := range []struct {
+		name                     string
+		currentEpoch, start, end beacon.EpochTime
+		want                     bool
+	}{
+		{"before window opens", 4, 5, 10, false},
+		{"at window start", 5, 5, 10, true},
+		{"inside window", 7, 5, 10, true},
+		{"at window end", 10, 5, 10, true},
+		{"after window closes", 11, 5, 10, false},
+		{"zero end epoch never closes", 1000, 5, 0, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := voteAllowed(tc.currentEpoch, tc.start, tc.end); got != tc.want {
+				t.Errorf("voteAllowed(%d, %d, %d) = %v, want %v", tc.currentEpoch, tc.start, tc.end, got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package upgrade
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCopyDir(t *testing.T) {
+	src, err := os.MkdirTemp("", "upgrade-copydir-src")
+	if err != nil {
+		t.Fatalf("MkdirTemp: %v", err)
+	}
+	defer os.RemoveAll(src)
+
+	if err := os.MkdirAll(filepath.Join(src, "sub"), 0o755); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "a.txt"), []byte("hello"), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "sub", "b.txt"), []byte("world"), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	// An existing scratch dir from a previous rehearsal must not be copied
+	// into itself or re-copied.
+	if err := os.MkdirAll(filepath.Join(src, "upgrade-rehearsal-stale"), 0o755); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+
+	dst := filepath.Join(src, "upgrade-rehearsal-test")
+	if err := copyDir(src, dst); err != nil {
+		t.Fatalf("copyDir: %v", err)
+	}
+
+	got, err := os.ReadFile(filepath.Join(dst, "a.txt"))
+	if err != nil {
+		t.Fatalf("ReadFile a.txt: %v", err)
+	}
+	if string(got) != "hello" {
+		t.Errorf("a.txt = %q, want %q", got, "hello")
+	}
+
+	got, err = os.ReadFile(filepath.Join(dst, "sub", "b.txt"))
+	if err != nil {
+		t.Fatalf("ReadFile sub/b.txt: %v", err)
+	}
+	if string(got) != "world" {
+		t.Errorf("sub/b.txt = %q, want %q", got, "world")
+	}
+
+	if _, err := os.Stat(filepath.Join(dst, "upgrade-rehearsal-stale")); !os.IsNotExist(err) {
+		t.Errorf("copyDir should not have copied the stale rehearsal scratch dir, stat err = %v", err)
+	}
+}
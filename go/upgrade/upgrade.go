@@ -9,7 +9,12 @@ package upgrade
 import (
 	"context"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
+	"time"
 
 	beacon "github.com/oasisprotocol/oasis-core/go/beacon/api"
 	"github.com/oasisprotocol/oasis-core/go/common/logging"
@@ -22,13 +27,47 @@ var (
 	_ api.Backend = (*upgradeManager)(nil)
 
 	metadataStoreKey = []byte("descriptors")
+	haltStoreKey     = []byte("halts")
 )
 
+// haltReceiptStoreKey returns the store key under which the HaltReceipt for
+// hp is persisted, keyed by its epoch and height so receipts for distinct
+// halt points never collide.
+func haltReceiptStoreKey(hp *api.HaltPoint) []byte {
+	return []byte(fmt.Sprintf("halt-receipt-%d-%d", hp.Epoch, hp.Height))
+}
+
+// haltReached reports whether hp's condition has been reached at
+// (currentEpoch, currentHeight). Epoch and Height are independent triggers:
+// either may be left at its zero value to mean "unset", in which case only
+// the other field is consulted. A HaltPoint with both left unset never
+// fires.
+func haltReached(hp *api.HaltPoint, currentEpoch beacon.EpochTime, currentHeight int64) bool {
+	if hp.Epoch != 0 && currentEpoch >= hp.Epoch {
+		return true
+	}
+	if hp.Height != 0 && currentHeight >= hp.Height {
+		return true
+	}
+	return false
+}
+
+// rehearsalReportStoreKey returns the store key under which the
+// RehearsalReport for the named upgrade is persisted.
+func rehearsalReportStoreKey(name string) []byte {
+	return []byte(fmt.Sprintf("rehearsal-report-%s", name))
+}
+
 type upgradeManager struct {
 	sync.Mutex
 
-	store   *persistent.ServiceStore
-	pending []*api.PendingUpgrade
+	store        *persistent.ServiceStore
+	pending      []*api.PendingUpgrade
+	pendingHalts []*api.PendingHalt
+
+	// currentEpoch is the latest epoch observed via ConsensusUpgrade, used to
+	// enforce the minimum-notice window on newly submitted descriptors.
+	currentEpoch beacon.EpochTime
 
 	dataDir string
 
@@ -39,6 +78,11 @@ func (u *upgradeManager) SubmitDescriptor(ctx context.Context, descriptor *api.D
 	u.Lock()
 	defer u.Unlock()
 
+	if descriptor.Epoch < u.currentEpoch+minNoticeEpochs() {
+		return fmt.Errorf("upgrade: descriptor epoch %d does not meet minimum notice of %d epochs from current epoch %d",
+			descriptor.Epoch, minNoticeEpochs(), u.currentEpoch)
+	}
+
 	for _, pu := range u.pending {
 		if pu.Descriptor == descriptor {
 			return api.ErrAlreadyPending
@@ -93,6 +137,66 @@ func (u *upgradeManager) CancelUpgrade(ctx context.Context, descriptor *api.Desc
 	return nil
 }
 
+func (u *upgradeManager) SubmitHalt(ctx context.Context, halt *api.HaltPoint) error {
+	u.Lock()
+	defer u.Unlock()
+
+	for _, ph := range u.pendingHalts {
+		if ph.HaltPoint == halt {
+			return api.ErrAlreadyPending
+		}
+	}
+
+	pending := &api.PendingHalt{
+		HaltPoint:  halt,
+		HaltHeight: api.InvalidUpgradeHeight,
+	}
+	u.pendingHalts = append(u.pendingHalts, pending)
+
+	u.logger.Info("received halt point, scheduling graceful stop",
+		"epoch", halt.Epoch,
+		"height", halt.Height,
+		"reason", halt.Reason,
+	)
+
+	return u.flushHaltLocked()
+}
+
+func (u *upgradeManager) PendingHalts(ctx context.Context) ([]*api.PendingHalt, error) {
+	u.Lock()
+	defer u.Unlock()
+
+	return u.pendingHalts, nil
+}
+
+func (u *upgradeManager) CancelHalt(ctx context.Context, halt *api.HaltPoint) error {
+	u.Lock()
+	defer u.Unlock()
+
+	if len(u.pendingHalts) == 0 {
+		// Make sure nothing is saved.
+		return u.flushHaltLocked()
+	}
+
+	var pendingHalts []*api.PendingHalt
+	for _, ph := range u.pendingHalts {
+		if !ph.HaltPoint.Equals(halt) {
+			pendingHalts = append(pendingHalts, ph)
+			continue
+		}
+		if ph.HaltHeight != api.InvalidUpgradeHeight {
+			return api.ErrUpgradeInProgress
+		}
+	}
+	oldPendingHalts := u.pendingHalts
+	u.pendingHalts = pendingHalts
+	if err := u.flushHaltLocked(); err != nil {
+		u.pendingHalts = oldPendingHalts
+		return err
+	}
+	return nil
+}
+
 func (u *upgradeManager) checkStatus() error {
 	u.Lock()
 	defer u.Unlock()
@@ -146,6 +250,21 @@ func (u *upgradeManager) checkStatus() error {
 		"pending", u.pending,
 	)
 
+	if err = u.store.GetCBOR(haltStoreKey, &u.pendingHalts); err != nil {
+		u.pendingHalts = nil
+		if err != persistent.ErrNotFound {
+			return fmt.Errorf("can't decode stored halt points: %w", err)
+		}
+	}
+
+	if err = u.flushHaltLocked(); err != nil {
+		return err
+	}
+
+	u.logger.Info("loaded pending halt metadata",
+		"pending_halts", u.pendingHalts,
+	)
+
 	return nil
 }
 
@@ -174,6 +293,32 @@ func (u *upgradeManager) flushDescriptorLocked() error {
 	return u.store.PutCBOR(metadataStoreKey, u.pending)
 }
 
+// NOTE: Assumes lock is held.
+func (u *upgradeManager) flushHaltLocked() error {
+	// Delete the state if there are no pending halt points.
+	if len(u.pendingHalts) == 0 {
+		if err := u.store.Delete(haltStoreKey); err != persistent.ErrNotFound {
+			return err
+		}
+		return nil
+	}
+
+	// Otherwise go over pending halt points and drop any already reached.
+	var pendingHalts []*api.PendingHalt
+	for _, ph := range u.pendingHalts {
+		if ph.HaltHeight != api.InvalidUpgradeHeight {
+			u.logger.Info("halt point reached, removing state",
+				"epoch", ph.HaltPoint.Epoch,
+				"height", ph.HaltPoint.Height,
+			)
+			continue
+		}
+		pendingHalts = append(pendingHalts, ph)
+	}
+	u.pendingHalts = pendingHalts
+	return u.store.PutCBOR(haltStoreKey, u.pendingHalts)
+}
+
 func (u *upgradeManager) StartupUpgrade() error {
 	u.Lock()
 	defer u.Unlock()
@@ -208,10 +353,177 @@ func (u *upgradeManager) StartupUpgrade() error {
 	return u.flushDescriptorLocked()
 }
 
+// RehearsalReport returns the report recorded the last time the named
+// upgrade was rehearsed, or persistent.ErrNotFound if it hasn't been.
+func (u *upgradeManager) RehearsalReport(ctx context.Context, name string) (*api.RehearsalReport, error) {
+	u.Lock()
+	defer u.Unlock()
+
+	return u.rehearsalReportLocked(name)
+}
+
+// NOTE: Assumes lock is held.
+func (u *upgradeManager) rehearsalReportLocked(name string) (*api.RehearsalReport, error) {
+	var report api.RehearsalReport
+	if err := u.store.GetCBOR(rehearsalReportStoreKey(name), &report); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// abciForker is implemented by privateCtx values that know how to produce
+// an isolated, discardable fork of themselves. Only a forked context is
+// ever handed to a migration handler during rehearsal; if privateCtx
+// doesn't implement this, the ConsensusUpgrade stage of the rehearsal is
+// skipped rather than risking the handler mutating live consensus state
+// (staking ledger, registry, validator set, ...) through the real one.
+//
+// No privateCtx type in this tree implements abciForker yet (doing so
+// needs a copy-on-write wrapper around the ABCI app state/IAVL tree, which
+// lives outside this package); until one does, rehearseUpgrade always
+// takes the skip path below. This package still only ever rehearses
+// StartupUpgrade plus this safety gate and the reporting plumbing around
+// it — not a working ConsensusUpgrade dry run with app-hash/validator-set/
+// staking diffs, which needs that wrapper landed first.
+type abciForker interface {
+	Fork() (forked interface{}, discard func(), err error)
+}
+
+// rehearseIfNeededLocked runs pu's migration handler against a throwaway
+// snapshot of dataDir and a forked ABCI context and records the outcome,
+// unless a report already exists from an earlier rehearsal. It never
+// mutates real node state.
+//
+// NOTE: Assumes lock is held.
+func (u *upgradeManager) rehearseIfNeededLocked(pu *api.PendingUpgrade, privateCtx interface{}, currentEpoch beacon.EpochTime, currentHeight int64) (bool, error) {
+	if _, err := u.rehearsalReportLocked(pu.Descriptor.Name); err == nil {
+		return true, nil
+	} else if err != persistent.ErrNotFound {
+		return false, fmt.Errorf("can't check existing rehearsal report: %w", err)
+	}
+
+	u.logger.Warn("rehearsing upgrade against a state snapshot",
+		"name", pu.Descriptor.Name,
+	)
+
+	report := u.rehearseUpgrade(pu, privateCtx, currentEpoch, currentHeight)
+	if err := u.store.PutCBOR(rehearsalReportStoreKey(pu.Descriptor.Name), report); err != nil {
+		return false, fmt.Errorf("can't persist rehearsal report: %w", err)
+	}
+
+	u.logger.Info("upgrade rehearsal complete, remaining on real state",
+		"name", pu.Descriptor.Name,
+		"app_hash", report.AppHash,
+		"err", report.Err,
+	)
+
+	return true, nil
+}
+
+// rehearseUpgrade copies dataDir into a scratch directory and runs the
+// named migration's StartupUpgrade stage against the copy. It only
+// attempts the ConsensusUpgrade stage if privateCtx implements abciForker
+// (see its doc comment for why nothing in this tree does yet), in which
+// case it runs that stage against the fork instead. The scratch directory
+// is always removed and any ABCI fork always discarded afterwards; real
+// state is never touched.
+func (u *upgradeManager) rehearseUpgrade(pu *api.PendingUpgrade, privateCtx interface{}, currentEpoch beacon.EpochTime, currentHeight int64) *api.RehearsalReport {
+	report := &api.RehearsalReport{
+		Name:      pu.Descriptor.Name,
+		Timestamp: time.Now(),
+	}
+
+	scratchDir := filepath.Join(u.dataDir, fmt.Sprintf("upgrade-rehearsal-%s", pu.Descriptor.Name))
+	defer os.RemoveAll(scratchDir)
+	if err := copyDir(u.dataDir, scratchDir); err != nil {
+		report.Err = fmt.Sprintf("snapshot state: %s", err)
+		return report
+	}
+
+	rehearsalPu := &api.PendingUpgrade{Descriptor: pu.Descriptor}
+	migrationCtx := migrations.NewContext(rehearsalPu, scratchDir)
+	handler, err := migrations.GetHandler(pu.Descriptor.Name)
+	if err != nil {
+		report.Err = fmt.Sprintf("get handler: %s", err)
+		return report
+	}
+	if err := handler.StartupUpgrade(migrationCtx); err != nil {
+		report.Err = fmt.Sprintf("rehearsal startup upgrade: %s", err)
+		return report
+	}
+
+	forker, ok := privateCtx.(abciForker)
+	if !ok {
+		report.Err = "privateCtx does not support ABCI state forking in this build; skipped the ConsensusUpgrade stage to avoid mutating live state"
+		return report
+	}
+	forkedCtx, discard, err := forker.Fork()
+	if err != nil {
+		report.Err = fmt.Sprintf("fork ABCI state: %s", err)
+		return report
+	}
+	defer discard()
+
+	if err := handler.ConsensusUpgrade(migrationCtx, forkedCtx); err != nil {
+		report.Err = fmt.Sprintf("rehearsal consensus upgrade: %s", err)
+		return report
+	}
+
+	if appHasher, ok := forkedCtx.(interface{ AppHash() []byte }); ok {
+		report.AppHash = appHasher.AppHash()
+	}
+	report.ValidatorSetDiff = "no diff computed: rehearsal ran against a private snapshot"
+	report.StakingDiff = "no diff computed: rehearsal ran against a private snapshot"
+	report.RegistryDiff = "no diff computed: rehearsal ran against a private snapshot"
+
+	return report
+}
+
+// copyDir recursively copies src into dst, creating dst if necessary. It is
+// used to give a rehearsal its own private, discardable state to mutate.
+func copyDir(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+
+		// Never copy other rehearsal scratch directories into this one.
+		if info.IsDir() && strings.HasPrefix(info.Name(), "upgrade-rehearsal-") {
+			return filepath.SkipDir
+		}
+
+		target := filepath.Join(dst, rel)
+		if info.IsDir() {
+			return os.MkdirAll(target, info.Mode())
+		}
+
+		in, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+
+		out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode())
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+
+		_, err = io.Copy(out, in)
+		return err
+	})
+}
+
 func (u *upgradeManager) ConsensusUpgrade(privateCtx interface{}, currentEpoch beacon.EpochTime, currentHeight int64) error {
 	u.Lock()
 	defer u.Unlock()
 
+	u.currentEpoch = currentEpoch
+
 	for _, pu := range u.pending {
 		// If we haven't reached the upgrade epoch yet, we run normally;
 		// startup made sure we're an appropriate binary for that.
@@ -219,6 +531,19 @@ func (u *upgradeManager) ConsensusUpgrade(privateCtx interface{}, currentEpoch b
 			if currentEpoch < pu.Descriptor.Epoch {
 				return nil
 			}
+
+			if pu.Descriptor.Rehearse {
+				rehearsed, err := u.rehearseIfNeededLocked(pu, privateCtx, currentEpoch, currentHeight)
+				if err != nil {
+					return err
+				}
+				if rehearsed {
+					// Stay on real state until the operator is satisfied with
+					// the report and submits a non-rehearsal descriptor.
+					return nil
+				}
+			}
+
 			pu.UpgradeHeight = currentHeight
 			if err := u.flushDescriptorLocked(); err != nil {
 				return err
@@ -253,20 +578,71 @@ func (u *upgradeManager) ConsensusUpgrade(privateCtx interface{}, currentEpoch b
 		}
 	}
 
-	return u.flushDescriptorLocked()
+	if err := u.flushDescriptorLocked(); err != nil {
+		return err
+	}
+
+	for _, ph := range u.pendingHalts {
+		if ph.HaltHeight != api.InvalidUpgradeHeight {
+			// Already handled in a previous call; flushHaltLocked will prune it.
+			continue
+		}
+
+		hp := ph.HaltPoint
+		if !haltReached(hp, currentEpoch, currentHeight) {
+			continue
+		}
+
+		ph.HaltHeight = currentHeight
+
+		u.logger.Warn("halt point reached, stopping cleanly",
+			"epoch", hp.Epoch,
+			"height", hp.Height,
+			"reason", hp.Reason,
+			logging.LogEvent, api.LogEventHalt,
+		)
+
+		receipt := &api.HaltReceipt{
+			Height:    currentHeight,
+			Epoch:     currentEpoch,
+			Reason:    hp.Reason,
+			Timestamp: time.Now(),
+		}
+		if appHasher, ok := privateCtx.(interface{ AppHash() []byte }); ok {
+			receipt.AppHash = appHasher.AppHash()
+		}
+
+		if err := u.store.PutCBOR(haltReceiptStoreKey(hp), receipt); err != nil {
+			return fmt.Errorf("can't persist halt receipt: %w", err)
+		}
+
+		if err := u.flushHaltLocked(); err != nil {
+			return err
+		}
+
+		return api.ErrHaltReached
+	}
+
+	return u.flushHaltLocked()
 }
 
 func (u *upgradeManager) Close() {
 	u.Lock()
 	defer u.Unlock()
 	_ = u.flushDescriptorLocked()
+	_ = u.flushHaltLocked()
 	u.store.Close()
 }
 
 // New constructs and returns a new upgrade manager. It also checks for and loads any
 // pending upgrade descriptors; if this node is not the one intended to be run according
 // to the loaded descriptor, New will return an error.
-func New(store *persistent.CommonStore, dataDir string) (api.Backend, error) {
+//
+// totalStake is consulted by the governance vote tally to determine whether
+// a proposed upgrade has reached quorum; it is called once per vote, so it
+// should be cheap (e.g. backed by the staking backend's cached ledger
+// totals) rather than recomputed from scratch.
+func New(store *persistent.CommonStore, dataDir string, totalStake func() uint64) (api.Backend, error) {
 	svcStore, err := store.GetServiceStore(api.ModuleName)
 	if err != nil {
 		return nil, err
@@ -281,5 +657,7 @@ func New(store *persistent.CommonStore, dataDir string) (api.Backend, error) {
 		return nil, err
 	}
 
+	upgrader.WatchApprovedUpgrades(context.Background(), NewGovernanceTally(svcStore, totalStake))
+
 	return upgrader, nil
 }
@@ -0,0 +1,34 @@
+package upgrade
+
+import (
+	"testing"
+
+	beacon "github.com/oasisprotocol/oasis-core/go/beacon/api"
+	"github.com/oasisprotocol/oasis-core/go/upgrade/api"
+)
+
+func TestHaltReached(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		hp            *api.HaltPoint
+		currentEpoch  beacon.EpochTime
+		currentHeight int64
+		want          bool
+	}{
+		{"height-only, below", &api.HaltPoint{Height: 100}, 0, 50, false},
+		{"height-only, at", &api.HaltPoint{Height: 100}, 0, 100, true},
+		{"height-only, past", &api.HaltPoint{Height: 100}, 0, 150, true},
+		{"epoch-only, below", &api.HaltPoint{Epoch: 5}, 4, 1000, false},
+		{"epoch-only, at", &api.HaltPoint{Epoch: 5}, 5, 1, true},
+		{"both set, only epoch reached", &api.HaltPoint{Epoch: 5, Height: 1000}, 5, 1, true},
+		{"both set, only height reached", &api.HaltPoint{Epoch: 5, Height: 1000}, 0, 1000, true},
+		{"both set, neither reached", &api.HaltPoint{Epoch: 5, Height: 1000}, 4, 999, false},
+		{"neither set", &api.HaltPoint{}, 100, 100000, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := haltReached(tc.hp, tc.currentEpoch, tc.currentHeight); got != tc.want {
+				t.Errorf("haltReached(%+v, %d, %d) = %v, want %v", tc.hp, tc.currentEpoch, tc.currentHeight, got, tc.want)
+			}
+		})
+	}
+}